@@ -0,0 +1,216 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// AudioSource fetches the best available audio and thumbnail for a track
+// from a particular provider, writing the audio to outputPath.
+type AudioSource interface {
+	Name() string
+	Fetch(ctx context.Context, track *Track, outputPath string) (audioPath, thumbPath string, err error)
+}
+
+// audioSources is the fallback chain fetchAudio walks in order, stopping at
+// the first source that successfully produces a file.
+var audioSources = []AudioSource{
+	&YouTubeSource{},
+	&BandcampSource{httpClient: &http.Client{Timeout: httpClientTimeout}},
+	&SoundCloudSource{},
+}
+
+// fetchAudio tries each source in turn, each against its own freshly
+// allocated output path, returning the first successful download. A failed
+// attempt's path is removed before the next source is tried, so a source
+// that exits non-zero after partially writing a file can't make the next
+// source's yt-dlp invocation see an "already downloaded" destination and
+// silently skip the fallback. If every source fails, the last error is
+// returned and no reserved path is left behind.
+func fetchAudio(ctx context.Context, sources []AudioSource, track *Track) (audioPath, thumbPath string, err error) {
+	var lastErr error
+	for _, source := range sources {
+		outputPath, pathErr := uniqueAudioPath()
+		if pathErr != nil {
+			return "", "", fmt.Errorf("error allocating audio path: %w", pathErr)
+		}
+
+		audioPath, thumbPath, err = source.Fetch(ctx, track, outputPath)
+		if err == nil {
+			return audioPath, thumbPath, nil
+		}
+
+		log.Printf("%s source failed: %v", source.Name(), err)
+		lastErr = err
+		removeAudioAttempt(outputPath)
+	}
+	return "", "", fmt.Errorf("all audio sources failed: %w", lastErr)
+}
+
+// removeAudioAttempt cleans up any audio or thumbnail file a failed source
+// may have left behind at outputPath.
+func removeAudioAttempt(outputPath string) {
+	os.Remove(outputPath)
+	if thumbnail, err := getThumbnail(outputPath); err == nil {
+		os.Remove(thumbnail)
+	}
+}
+
+// uniqueAudioPath reserves a unique filename under os.TempDir() so
+// concurrent download workers never collide on the same file.
+func uniqueAudioPath() (string, error) {
+	f, err := os.CreateTemp("", "wave-audio-*.mp3")
+	if err != nil {
+		return "", err
+	}
+	path := f.Name()
+	f.Close()
+	os.Remove(path)
+	return path, nil
+}
+
+// YouTubeSource downloads audio via yt-dlp's YouTube search.
+type YouTubeSource struct{}
+
+func (s *YouTubeSource) Name() string { return "youtube" }
+
+func (s *YouTubeSource) Fetch(ctx context.Context, track *Track, outputPath string) (string, string, error) {
+	query := fmt.Sprintf("%s %s", track.Name, strings.Join(track.Artists, " "))
+	cmd := exec.CommandContext(ctx, "yt-dlp", "-x", "--audio-format", "mp3", "--write-thumbnail", "--convert-thumbnails", "jpg", "-o", outputPath, "ytsearch1:"+query)
+	if err := cmd.Run(); err != nil {
+		return "", "", fmt.Errorf("yt-dlp youtube search failed: %w", err)
+	}
+
+	thumbnail, err := getThumbnail(outputPath)
+	if err != nil {
+		log.Printf("Error getting thumbnail: %v", err)
+	}
+
+	return outputPath, thumbnail, nil
+}
+
+// SoundCloudSource downloads audio via yt-dlp's SoundCloud search extractor.
+type SoundCloudSource struct{}
+
+func (s *SoundCloudSource) Name() string { return "soundcloud" }
+
+func (s *SoundCloudSource) Fetch(ctx context.Context, track *Track, outputPath string) (string, string, error) {
+	query := fmt.Sprintf("%s %s", track.Name, strings.Join(track.Artists, " "))
+	cmd := exec.CommandContext(ctx, "yt-dlp", "-x", "--audio-format", "mp3", "--write-thumbnail", "--convert-thumbnails", "jpg", "-o", outputPath, "scsearch1:"+query)
+	if err := cmd.Run(); err != nil {
+		return "", "", fmt.Errorf("yt-dlp soundcloud search failed: %w", err)
+	}
+
+	thumbnail, err := getThumbnail(outputPath)
+	if err != nil {
+		log.Printf("Error getting thumbnail: %v", err)
+	}
+
+	return outputPath, thumbnail, nil
+}
+
+// BandcampSource looks up a track on Bandcamp and hands the matched URL to
+// yt-dlp, since yt-dlp has no native Bandcamp search extractor.
+type BandcampSource struct {
+	httpClient *http.Client
+}
+
+func (s *BandcampSource) Name() string { return "bandcamp" }
+
+const bandcampSearchURL = "https://bandcamp.com/api/bcsearch_public_api/1/autocomplete_elastic"
+
+type bandcampSearchResponse struct {
+	Auto struct {
+		Results []struct {
+			Type     string `json:"type"`
+			Name     string `json:"name"`
+			BandName string `json:"band_name"`
+			URL      string `json:"item_url_root"`
+		} `json:"results"`
+	} `json:"auto"`
+}
+
+func (s *BandcampSource) Fetch(ctx context.Context, track *Track, outputPath string) (string, string, error) {
+	matchURL, err := s.search(ctx, track)
+	if err != nil {
+		return "", "", err
+	}
+
+	cmd := exec.CommandContext(ctx, "yt-dlp", "-x", "--audio-format", "mp3", "--write-thumbnail", "--convert-thumbnails", "jpg", "-o", outputPath, matchURL)
+	if err := cmd.Run(); err != nil {
+		return "", "", fmt.Errorf("yt-dlp bandcamp download failed: %w", err)
+	}
+
+	thumbnail, err := getThumbnail(outputPath)
+	if err != nil {
+		log.Printf("Error getting thumbnail: %v", err)
+	}
+
+	return outputPath, thumbnail, nil
+}
+
+// search queries Bandcamp's autocomplete endpoint and returns the URL of the
+// first track/album result whose title and artist match the Spotify
+// metadata, following the matching approach used by spotifytobandcamp.
+func (s *BandcampSource) search(ctx context.Context, track *Track) (string, error) {
+	query := fmt.Sprintf("%s %s", track.Name, strings.Join(track.Artists, " "))
+	payload := fmt.Sprintf(`{"search_text":%q,"fan_id":null,"full_page":false}`, query)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, bandcampSearchURL, strings.NewReader(payload))
+	if err != nil {
+		return "", fmt.Errorf("error creating request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := s.httpClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("error sending request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("unexpected status code %d", resp.StatusCode)
+	}
+
+	var searchResp bandcampSearchResponse
+	if err := json.NewDecoder(resp.Body).Decode(&searchResp); err != nil {
+		return "", fmt.Errorf("error decoding response: %w", err)
+	}
+
+	for _, result := range searchResp.Auto.Results {
+		if result.Type != "track" && result.Type != "album" {
+			continue
+		}
+		if !titleMatches(result.Name, track.Name) || !artistMatches(result.BandName, track.Artists) {
+			continue
+		}
+		return result.URL, nil
+	}
+
+	return "", fmt.Errorf("no matching bandcamp result for %q", query)
+}
+
+// titleMatches reports whether two track titles refer to the same song,
+// tolerating extra annotations like "(Remastered)" on either side.
+func titleMatches(a, b string) bool {
+	a, b = strings.ToLower(strings.TrimSpace(a)), strings.ToLower(strings.TrimSpace(b))
+	return strings.Contains(a, b) || strings.Contains(b, a)
+}
+
+// artistMatches reports whether a Bandcamp band name equals any of the
+// Spotify track's artists, case-insensitively.
+func artistMatches(bandName string, artists []string) bool {
+	bandName = strings.ToLower(strings.TrimSpace(bandName))
+	for _, artist := range artists {
+		if strings.ToLower(strings.TrimSpace(artist)) == bandName {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,249 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+	"time"
+)
+
+const (
+	spotifyAuthorizeURL  = "https://accounts.spotify.com/authorize"
+	authScope            = "user-read-currently-playing"
+	authCallbackPath     = "/callback"
+	defaultAuthPort      = "8888"
+	authServerTimeout    = 2 * time.Minute
+	envFilePath          = ".env"
+	refreshTokenEnvKey   = "SPOTIFY_REFRESH_TOKEN"
+	authRandomBytesLen   = 32
+	authCallbackBodyText = "Authentication complete, you can close this tab and return to the terminal."
+)
+
+// runAuth drives the `wave auth` subcommand: it runs the Spotify
+// authorization-code flow with PKCE through a temporary local callback
+// server and writes the resulting refresh token to .env.
+func runAuth() error {
+	clientID := os.Getenv("SPOTIFY_CLIENT_ID")
+	if clientID == "" {
+		return fmt.Errorf("missing required SPOTIFY_CLIENT_ID environment variable")
+	}
+	clientSecret := os.Getenv("SPOTIFY_CLIENT_SECRET")
+
+	port := os.Getenv("AUTH_CALLBACK_PORT")
+	if port == "" {
+		port = defaultAuthPort
+	}
+	redirectURI := fmt.Sprintf("http://127.0.0.1:%s%s", port, authCallbackPath)
+
+	state, err := randomURLSafeString()
+	if err != nil {
+		return fmt.Errorf("error generating state: %w", err)
+	}
+
+	verifier, err := randomURLSafeString()
+	if err != nil {
+		return fmt.Errorf("error generating code verifier: %w", err)
+	}
+	challenge := codeChallengeS256(verifier)
+
+	authorizeURL := buildAuthorizeURL(clientID, redirectURI, state, challenge)
+
+	code, err := awaitAuthorizationCode(port, state, authorizeURL)
+	if err != nil {
+		return fmt.Errorf("error awaiting authorization code: %w", err)
+	}
+
+	refreshToken, err := exchangeAuthorizationCode(clientID, clientSecret, code, redirectURI, verifier)
+	if err != nil {
+		return fmt.Errorf("error exchanging authorization code: %w", err)
+	}
+
+	if err := writeRefreshTokenToEnv(refreshToken); err != nil {
+		return fmt.Errorf("error writing refresh token: %w", err)
+	}
+
+	fmt.Println("Authorization complete. SPOTIFY_REFRESH_TOKEN written to .env")
+	return nil
+}
+
+func buildAuthorizeURL(clientID, redirectURI, state, challenge string) string {
+	query := url.Values{}
+	query.Set("client_id", clientID)
+	query.Set("response_type", "code")
+	query.Set("redirect_uri", redirectURI)
+	query.Set("scope", authScope)
+	query.Set("state", state)
+	query.Set("code_challenge_method", "S256")
+	query.Set("code_challenge", challenge)
+
+	return spotifyAuthorizeURL + "?" + query.Encode()
+}
+
+// awaitAuthorizationCode opens the authorize URL in the user's browser,
+// serves a single callback request, and returns the authorization code once
+// the state parameter has been verified.
+func awaitAuthorizationCode(port, expectedState, authorizeURL string) (string, error) {
+	codeCh := make(chan string, 1)
+	errCh := make(chan error, 1)
+
+	mux := http.NewServeMux()
+	server := &http.Server{Addr: ":" + port, Handler: mux}
+
+	mux.HandleFunc(authCallbackPath, func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+
+		if errParam := query.Get("error"); errParam != "" {
+			errCh <- fmt.Errorf("authorization denied: %s", errParam)
+			http.Error(w, errParam, http.StatusBadRequest)
+			return
+		}
+
+		if query.Get("state") != expectedState {
+			errCh <- fmt.Errorf("state mismatch: possible CSRF attempt")
+			http.Error(w, "invalid state", http.StatusBadRequest)
+			return
+		}
+
+		code := query.Get("code")
+		if code == "" {
+			errCh <- fmt.Errorf("no authorization code in callback")
+			http.Error(w, "missing code", http.StatusBadRequest)
+			return
+		}
+
+		fmt.Fprint(w, authCallbackBodyText)
+		codeCh <- code
+	})
+
+	go func() {
+		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			errCh <- fmt.Errorf("callback server error: %w", err)
+		}
+	}()
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		server.Shutdown(ctx)
+	}()
+
+	fmt.Printf("Open the following URL in your browser to authorize wave:\n\n%s\n\n", authorizeURL)
+	openBrowser(authorizeURL)
+
+	select {
+	case code := <-codeCh:
+		return code, nil
+	case err := <-errCh:
+		return "", err
+	case <-time.After(authServerTimeout):
+		return "", fmt.Errorf("timed out waiting for authorization callback")
+	}
+}
+
+func exchangeAuthorizationCode(clientID, clientSecret, code, redirectURI, verifier string) (string, error) {
+	data := url.Values{}
+	data.Set("grant_type", "authorization_code")
+	data.Set("code", code)
+	data.Set("redirect_uri", redirectURI)
+	data.Set("client_id", clientID)
+	data.Set("code_verifier", verifier)
+
+	ctx, cancel := context.WithTimeout(context.Background(), httpClientTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, spotifyTokenURL, strings.NewReader(data.Encode()))
+	if err != nil {
+		return "", fmt.Errorf("error creating request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	if clientSecret != "" {
+		auth := base64.StdEncoding.EncodeToString([]byte(clientID + ":" + clientSecret))
+		req.Header.Set("Authorization", "Basic "+auth)
+	}
+
+	client := &http.Client{Timeout: httpClientTimeout}
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("error sending request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return "", fmt.Errorf("unexpected status code %d: %s", resp.StatusCode, body)
+	}
+
+	var tokenResp struct {
+		RefreshToken string `json:"refresh_token"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&tokenResp); err != nil {
+		return "", fmt.Errorf("error decoding response: %w", err)
+	}
+	if tokenResp.RefreshToken == "" {
+		return "", fmt.Errorf("token response did not include a refresh token")
+	}
+
+	return tokenResp.RefreshToken, nil
+}
+
+// writeRefreshTokenToEnv updates SPOTIFY_REFRESH_TOKEN in .env, preserving
+// any other variables already present in the file.
+func writeRefreshTokenToEnv(refreshToken string) error {
+	existing, _ := os.ReadFile(envFilePath)
+
+	lines := []string{}
+	replaced := false
+	for _, line := range strings.Split(string(existing), "\n") {
+		if strings.HasPrefix(line, refreshTokenEnvKey+"=") {
+			lines = append(lines, fmt.Sprintf("%s=%s", refreshTokenEnvKey, refreshToken))
+			replaced = true
+			continue
+		}
+		if line != "" {
+			lines = append(lines, line)
+		}
+	}
+	if !replaced {
+		lines = append(lines, fmt.Sprintf("%s=%s", refreshTokenEnvKey, refreshToken))
+	}
+
+	return os.WriteFile(envFilePath, []byte(strings.Join(lines, "\n")+"\n"), 0600)
+}
+
+func randomURLSafeString() (string, error) {
+	buf := make([]byte, authRandomBytesLen)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
+
+func codeChallengeS256(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// openBrowser best-effort opens url in the system's default browser. Failure
+// is non-fatal since the URL is also printed for the user to open manually.
+func openBrowser(url string) {
+	var cmd *exec.Cmd
+	switch runtime.GOOS {
+	case "darwin":
+		cmd = exec.Command("open", url)
+	case "windows":
+		cmd = exec.Command("rundll32", "url.dll,FileProtocolHandler", url)
+	default:
+		cmd = exec.Command("xdg-open", url)
+	}
+	_ = cmd.Start()
+}
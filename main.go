@@ -11,10 +11,12 @@ import (
 	"net/http"
 	"net/url"
 	"os"
-	"os/exec"
+	"os/signal"
 	"path/filepath"
 	"strconv"
 	"strings"
+	"sync"
+	"syscall"
 	"time"
 
 	"github.com/joho/godotenv"
@@ -25,7 +27,11 @@ const (
 	spotifyTokenURL   = "https://accounts.spotify.com/api/token"
 	telegramAPIBase   = "https://api.telegram.org/bot%s/%s"
 	httpClientTimeout = 10 * time.Second
-	audioFileName     = "audio.mp3"
+	tokenExpirySkew   = 60 * time.Second
+	minPollInterval   = 5 * time.Second
+	pollJitter        = 2 * time.Second
+
+	defaultDownloadWorkers = 2
 )
 
 type (
@@ -35,6 +41,10 @@ type (
 		refreshToken    string
 		httpClient      *http.Client
 		refreshInterval time.Duration
+
+		tokenMu     sync.Mutex
+		accessToken string
+		expiresAt   time.Time
 	}
 
 	TelegramClient struct {
@@ -45,8 +55,12 @@ type (
 	}
 
 	Track struct {
-		Name    string   `json:"name"`
-		Artists []string `json:"artists"`
+		URI        string   `json:"uri"`
+		Name       string   `json:"name"`
+		Artists    []string `json:"artists"`
+		ProgressMs int      `json:"progress_ms"`
+		DurationMs int      `json:"duration_ms"`
+		IsPlaying  bool     `json:"is_playing"`
 	}
 
 	SpotifyTokenResponse struct {
@@ -58,6 +72,13 @@ type (
 )
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "auth" {
+		if err := runAuth(); err != nil {
+			log.Fatalf("Error: %v", err)
+		}
+		return
+	}
+
 	if err := run(); err != nil {
 		log.Fatalf("Error: %v", err)
 	}
@@ -71,21 +92,44 @@ func run() error {
 		return fmt.Errorf("error creating spotify client: %w", err)
 	}
 
-	telegramClient, err := newTelegramClient()
+	store, err := newStore("wave.db")
+	if err != nil {
+		return fmt.Errorf("error opening track store: %w", err)
+	}
+	defer store.Close()
+
+	telegramClient, err := newTelegramClient(store)
 	if err != nil {
 		return fmt.Errorf("error creating telegram client: %w", err)
 	}
 
-	ticker := time.NewTicker(spotifyClient.refreshInterval)
-	defer ticker.Stop()
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
 
-	for range ticker.C {
-		if err := processCurrentTrack(spotifyClient, telegramClient); err != nil {
-			log.Printf("Error processing current track: %v", err)
-		}
+	return runPipeline(ctx, spotifyClient, telegramClient, store, downloadWorkerCount())
+}
+
+func downloadWorkerCount() int {
+	n, err := strconv.Atoi(os.Getenv("DOWNLOAD_WORKERS"))
+	if err != nil || n < 1 {
+		return defaultDownloadWorkers
 	}
+	return n
+}
 
-	return nil
+// nextPollInterval returns how long to sleep so the next poll lands shortly
+// after the current track is expected to end, so a track change is noticed
+// promptly without polling Spotify throughout long tracks. It never returns
+// more than maxInterval.
+func nextPollInterval(track *Track, maxInterval time.Duration) time.Duration {
+	remaining := time.Duration(track.DurationMs-track.ProgressMs)*time.Millisecond + pollJitter
+	if remaining < minPollInterval {
+		remaining = minPollInterval
+	}
+	if remaining > maxInterval {
+		return maxInterval
+	}
+	return remaining
 }
 
 func newSpotifyClient() (*SpotifyClient, error) {
@@ -114,7 +158,7 @@ func newSpotifyClient() (*SpotifyClient, error) {
 	}, nil
 }
 
-func newTelegramClient() (*TelegramClient, error) {
+func newTelegramClient(store *Store) (*TelegramClient, error) {
 	botToken := os.Getenv("BOT_TOKEN")
 	chatID := os.Getenv("CHAT_ID")
 
@@ -122,14 +166,29 @@ func newTelegramClient() (*TelegramClient, error) {
 		return nil, fmt.Errorf("missing required telegram environment variables")
 	}
 
-	return &TelegramClient{
+	client := &TelegramClient{
 		botToken:   botToken,
 		chatID:     chatID,
 		httpClient: &http.Client{Timeout: httpClientTimeout},
-	}, nil
+	}
+
+	if messageID, ok, err := store.GetNowPlayingMessageID(); err != nil {
+		return nil, fmt.Errorf("error loading now-playing message id: %w", err)
+	} else if ok {
+		client.messageID = &messageID
+	}
+
+	return client, nil
 }
 
 func (c *SpotifyClient) getAccessToken(ctx context.Context) (string, error) {
+	c.tokenMu.Lock()
+	defer c.tokenMu.Unlock()
+
+	if c.accessToken != "" && time.Now().Before(c.expiresAt.Add(-tokenExpirySkew)) {
+		return c.accessToken, nil
+	}
+
 	data := url.Values{}
 	data.Set("grant_type", "refresh_token")
 	data.Set("refresh_token", c.refreshToken)
@@ -159,7 +218,10 @@ func (c *SpotifyClient) getAccessToken(ctx context.Context) (string, error) {
 		return "", fmt.Errorf("error decoding response: %w", err)
 	}
 
-	return tokenResp.AccessToken, nil
+	c.accessToken = tokenResp.AccessToken
+	c.expiresAt = time.Now().Add(time.Duration(tokenResp.ExpiresIn) * time.Second)
+
+	return c.accessToken, nil
 }
 
 func (c *SpotifyClient) getCurrentTrack(ctx context.Context) (*Track, error) {
@@ -189,11 +251,15 @@ func (c *SpotifyClient) getCurrentTrack(ctx context.Context) (*Track, error) {
 	}
 
 	var response struct {
-		Item struct {
+		ProgressMs int  `json:"progress_ms"`
+		IsPlaying  bool `json:"is_playing"`
+		Item       struct {
+			URI     string `json:"uri"`
 			Name    string `json:"name"`
 			Artists []struct {
 				Name string `json:"name"`
 			} `json:"artists"`
+			DurationMs int `json:"duration_ms"`
 		} `json:"item"`
 	}
 	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
@@ -206,18 +272,47 @@ func (c *SpotifyClient) getCurrentTrack(ctx context.Context) (*Track, error) {
 	}
 
 	return &Track{
-		Name:    response.Item.Name,
-		Artists: artists,
+		URI:        response.Item.URI,
+		Name:       response.Item.Name,
+		Artists:    artists,
+		ProgressMs: response.ProgressMs,
+		DurationMs: response.Item.DurationMs,
+		IsPlaying:  response.IsPlaying,
 	}, nil
 }
 
-func (t *TelegramClient) sendOrEditAudio(ctx context.Context, filePath, title, performer, thumbnail string) error {
+// sendOrEditAudio uploads a local audio file, sending a fresh message or
+// editing the current "now playing" message if one is already tracked. It
+// returns the Telegram file_id of the uploaded audio so callers can cache it
+// and skip re-uploading the same track later.
+func (t *TelegramClient) sendOrEditAudio(ctx context.Context, filePath, title, performer, thumbnail string) (string, error) {
 	file, err := os.Open(filePath)
 	if err != nil {
-		return fmt.Errorf("error opening audio file: %w", err)
+		return "", fmt.Errorf("error opening audio file: %w", err)
 	}
 	defer file.Close()
 
+	return t.sendAudio(ctx, "attach://audio", func(writer *multipart.Writer) error {
+		part, err := writer.CreateFormFile("audio", filepath.Base(filePath))
+		if err != nil {
+			return fmt.Errorf("error creating form file: %w", err)
+		}
+		if _, err := io.Copy(part, file); err != nil {
+			return fmt.Errorf("error copying audio file: %w", err)
+		}
+		return nil
+	}, title, performer, thumbnail)
+}
+
+// sendCachedAudio re-sends or edits-in a previously uploaded audio file by
+// its Telegram file_id, skipping local file upload entirely.
+func (t *TelegramClient) sendCachedAudio(ctx context.Context, fileID, title, performer string) (string, error) {
+	return t.sendAudio(ctx, fileID, func(writer *multipart.Writer) error {
+		return writer.WriteField("audio", fileID)
+	}, title, performer, "")
+}
+
+func (t *TelegramClient) sendAudio(ctx context.Context, audioRef string, writeAudio func(*multipart.Writer) error, title, performer, thumbnail string) (string, error) {
 	body := &strings.Builder{}
 	writer := multipart.NewWriter(body)
 
@@ -228,84 +323,82 @@ func (t *TelegramClient) sendOrEditAudio(ctx context.Context, filePath, title, p
 	if t.messageID != nil {
 		url = fmt.Sprintf(telegramAPIBase, t.botToken, "editMessageMedia")
 		writer.WriteField("message_id", fmt.Sprintf("%d", *t.messageID))
-		media := fmt.Sprintf(`{"type":"audio","media":"attach://audio", "thumbnail":"attach://thumbnail", "title":"%s", "performer":"%s"}`, title, performer)
+
+		thumbnailField := ""
+		if thumbnail != "" {
+			thumbnailField = `, "thumbnail":"attach://thumbnail"`
+		}
+		media := fmt.Sprintf(`{"type":"audio","media":"%s"%s, "title":"%s", "performer":"%s"}`, audioRef, thumbnailField, title, performer)
 		writer.WriteField("media", media)
 	} else {
 		writer.WriteField("title", title)
 		writer.WriteField("performer", performer)
 	}
 
-	part, err := writer.CreateFormFile("audio", filepath.Base(filePath))
-	if err != nil {
-		return fmt.Errorf("error creating form file: %w", err)
-	}
-	if _, err := io.Copy(part, file); err != nil {
-		return fmt.Errorf("error copying audio file: %w", err)
+	if err := writeAudio(writer); err != nil {
+		return "", err
 	}
 
 	if thumbnail != "" {
 		thumbFile, err := os.Open(thumbnail)
 		if err != nil {
-			return fmt.Errorf("error opening thumbnail file: %w", err)
+			return "", fmt.Errorf("error opening thumbnail file: %w", err)
 		}
 		defer thumbFile.Close()
 
 		part, err := writer.CreateFormFile("thumbnail", filepath.Base(thumbnail))
 		if err != nil {
-			return fmt.Errorf("error creating thumbnail form file: %w", err)
+			return "", fmt.Errorf("error creating thumbnail form file: %w", err)
 		}
 		if _, err := io.Copy(part, thumbFile); err != nil {
-			return fmt.Errorf("error copying thumbnail file: %w", err)
+			return "", fmt.Errorf("error copying thumbnail file: %w", err)
 		}
 	}
 
 	if err := writer.Close(); err != nil {
-		return fmt.Errorf("error closing multipart writer: %w", err)
+		return "", fmt.Errorf("error closing multipart writer: %w", err)
 	}
 
 	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, strings.NewReader(body.String()))
 	if err != nil {
-		return fmt.Errorf("error creating request: %w", err)
+		return "", fmt.Errorf("error creating request: %w", err)
 	}
 	req.Header.Set("Content-Type", writer.FormDataContentType())
 
 	resp, err := t.httpClient.Do(req)
 	if err != nil {
-		return fmt.Errorf("error sending request: %w", err)
+		return "", fmt.Errorf("error sending request: %w", err)
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
 		body, _ := io.ReadAll(resp.Body)
-		return fmt.Errorf("unexpected status code %d: %s", resp.StatusCode, body)
+		return "", fmt.Errorf("unexpected status code %d: %s", resp.StatusCode, body)
 	}
 
 	var respData struct {
 		Ok     bool `json:"ok"`
 		Result struct {
 			MessageID int `json:"message_id"`
+			Audio     struct {
+				FileID string `json:"file_id"`
+			} `json:"audio"`
 		} `json:"result"`
 		Description string `json:"description,omitempty"`
 		ErrorCode   int    `json:"error_code,omitempty"`
 	}
 
 	if err := json.NewDecoder(resp.Body).Decode(&respData); err != nil {
-		return fmt.Errorf("failed to decode message_id: %w", err)
+		return "", fmt.Errorf("failed to decode message_id: %w", err)
 	}
 
 	if !respData.Ok {
-		return fmt.Errorf("request failed: %s (error code: %d)", respData.Description, respData.ErrorCode)
+		return "", fmt.Errorf("request failed: %s (error code: %d)", respData.Description, respData.ErrorCode)
 	}
 
 	t.messageID = &respData.Result.MessageID
 
-	return nil
-}
-
-func downloadFromYouTube(track *Track) error {
-	query := fmt.Sprintf("%s %s", track.Name, strings.Join(track.Artists, " "))
-	cmd := exec.Command("yt-dlp", "-x", "--audio-format", "mp3", "--write-thumbnail", "--convert-thumbnails", "jpg", "-o", audioFileName, "ytsearch1:"+query)
-	return cmd.Run()
+	return respData.Result.Audio.FileID, nil
 }
 
 func getThumbnail(filename string) (string, error) {
@@ -315,45 +408,3 @@ func getThumbnail(filename string) (string, error) {
 	}
 	return "", fmt.Errorf("thumbnail not found")
 }
-
-func processCurrentTrack(spotifyClient *SpotifyClient, telegramClient *TelegramClient) error {
-	getCurrentCtx, cancel := context.WithTimeout(context.Background(), time.Second*10)
-	defer cancel()
-
-	track, err := spotifyClient.getCurrentTrack(getCurrentCtx)
-	if err != nil {
-		return fmt.Errorf("error getting current track: %w", err)
-	}
-	if track == nil {
-		log.Println("No track currently playing")
-		return nil
-	}
-
-	trackKey := fmt.Sprintf("%s - %s", track.Name, strings.Join(track.Artists, ", "))
-	log.Printf("Current track: %s", trackKey)
-
-	log.Printf("Downloading: %s", trackKey)
-	if err := downloadFromYouTube(track); err != nil {
-		return fmt.Errorf("error downloading from youtube: %w", err)
-	}
-
-	thumbnail, err := getThumbnail(audioFileName)
-	if err != nil {
-		log.Printf("Error getting thumbnail: %v", err)
-	}
-
-	sendCtx, cancel := context.WithTimeout(context.Background(), time.Minute*2)
-	defer cancel()
-
-	log.Printf("Uploading to Telegram: %s", trackKey)
-	if err := telegramClient.sendOrEditAudio(sendCtx, audioFileName, track.Name, strings.Join(track.Artists, ", "), thumbnail); err != nil {
-		return fmt.Errorf("error uploading to telegram: %w", err)
-	}
-
-	os.Remove(audioFileName)
-	if thumbnail != "" {
-		os.Remove(thumbnail)
-	}
-
-	return nil
-}
@@ -0,0 +1,225 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// pipelineQueueSize bounds how many tracks may be queued ahead of the
+// download workers and the Telegram sender before producers block.
+const pipelineQueueSize = 4
+
+// downloadJob is a track queued for a download worker to fetch audio for.
+type downloadJob struct {
+	track *Track
+}
+
+// uploadJob is a finished (or cached) audio ready for the Telegram sender.
+// fromCache is set when the job skipped downloading entirely because a
+// file_id was already on record for this track.
+type uploadJob struct {
+	track        *Track
+	audioPath    string
+	thumbPath    string
+	fromCache    bool
+	fileID       string
+	downloadedAt time.Time
+}
+
+// trackResult reports how a track's download/upload attempt ended, so the
+// producer can tell apart a track that was delivered from one that needs to
+// be retried on the next poll.
+type trackResult struct {
+	uri     string
+	success bool
+}
+
+// runPipeline wires the producer -> download workers -> sender stages
+// together and blocks until ctx is cancelled and every in-flight job has
+// been sent, so a SIGINT/SIGTERM lets uploads that are already underway
+// finish instead of dropping them.
+func runPipeline(ctx context.Context, spotifyClient *SpotifyClient, telegramClient *TelegramClient, store *Store, workerCount int) error {
+	downloadJobs := make(chan downloadJob, pipelineQueueSize)
+	uploadJobs := make(chan uploadJob, pipelineQueueSize)
+	results := make(chan trackResult, pipelineQueueSize)
+
+	var workers sync.WaitGroup
+	for i := 0; i < workerCount; i++ {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			downloadWorker(downloadJobs, uploadJobs, results)
+		}()
+	}
+
+	senderDone := make(chan struct{})
+	go func() {
+		defer close(senderDone)
+		sendWorker(telegramClient, store, uploadJobs, results)
+	}()
+
+	produceTracks(ctx, spotifyClient, store, downloadJobs, uploadJobs, results)
+
+	close(downloadJobs)
+	workers.Wait()
+	close(uploadJobs)
+	<-senderDone
+
+	return nil
+}
+
+// produceTracks polls Spotify on a progress-aware schedule and enqueues a
+// job for each newly detected track: straight to the sender if a cached
+// upload already exists, otherwise to the download workers. It returns once
+// ctx is cancelled.
+//
+// A track is only considered "handled" once its download/upload attempt
+// reports success on results; a failure clears lastTrackURI so the same
+// track is retried on the next poll instead of being skipped for the rest
+// of its playback.
+func produceTracks(ctx context.Context, spotifyClient *SpotifyClient, store *Store, downloadJobs chan<- downloadJob, uploadJobs chan<- uploadJob, results <-chan trackResult) {
+	lastTrackURI := ""
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case res := <-results:
+			applyTrackResult(&lastTrackURI, res)
+			continue
+		default:
+		}
+
+		getCurrentCtx, cancel := context.WithTimeout(ctx, time.Second*10)
+		track, err := spotifyClient.getCurrentTrack(getCurrentCtx)
+		cancel()
+
+		sleepFor := spotifyClient.refreshInterval
+
+		switch {
+		case err != nil:
+			log.Printf("Error getting current track: %v", err)
+		case track == nil || !track.IsPlaying:
+			log.Println("No track currently playing")
+			lastTrackURI = ""
+		case track.URI != lastTrackURI:
+			if enqueueTrack(track, store, downloadJobs, uploadJobs) {
+				lastTrackURI = track.URI
+			}
+			sleepFor = nextPollInterval(track, spotifyClient.refreshInterval)
+		default:
+			sleepFor = nextPollInterval(track, spotifyClient.refreshInterval)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case res := <-results:
+			applyTrackResult(&lastTrackURI, res)
+		case <-time.After(sleepFor):
+		}
+	}
+}
+
+// applyTrackResult clears lastTrackURI on a failed attempt so produceTracks
+// re-enqueues the same track on its next poll instead of treating it as
+// already handled.
+func applyTrackResult(lastTrackURI *string, res trackResult) {
+	if !res.success && res.uri == *lastTrackURI {
+		*lastTrackURI = ""
+	}
+}
+
+func enqueueTrack(track *Track, store *Store, downloadJobs chan<- downloadJob, uploadJobs chan<- uploadJob) bool {
+	record, err := store.GetTrack(track.URI)
+	if err != nil {
+		log.Printf("Error reading track store: %v", err)
+		return false
+	}
+
+	if record != nil && record.FileID != "" {
+		uploadJobs <- uploadJob{track: track, fromCache: true, fileID: record.FileID, downloadedAt: record.DownloadedAt}
+		return true
+	}
+
+	downloadJobs <- downloadJob{track: track}
+	return true
+}
+
+// downloadWorker fetches audio for each queued track and forwards the
+// result to the sender. Running N of these lets one track's download
+// overlap with another's upload.
+func downloadWorker(downloadJobs <-chan downloadJob, uploadJobs chan<- uploadJob, results chan<- trackResult) {
+	for job := range downloadJobs {
+		trackKey := trackKey(job.track)
+		log.Printf("Downloading: %s", trackKey)
+
+		downloadCtx, cancel := context.WithTimeout(context.Background(), time.Minute*2)
+		audioPath, thumbPath, err := fetchAudio(downloadCtx, audioSources, job.track)
+		cancel()
+		if err != nil {
+			log.Printf("Error fetching audio for %s: %v", trackKey, err)
+			results <- trackResult{uri: job.track.URI, success: false}
+			continue
+		}
+
+		uploadJobs <- uploadJob{track: job.track, audioPath: audioPath, thumbPath: thumbPath, downloadedAt: time.Now()}
+	}
+}
+
+// sendWorker is the single consumer that talks to Telegram, since the
+// send-or-edit flow mutates TelegramClient.messageID and must stay
+// serialized.
+func sendWorker(telegramClient *TelegramClient, store *Store, uploadJobs <-chan uploadJob, results chan<- trackResult) {
+	for job := range uploadJobs {
+		err := sendUploadJob(telegramClient, store, job)
+		if err != nil {
+			log.Printf("Error sending %s to telegram: %v", trackKey(job.track), err)
+		}
+		results <- trackResult{uri: job.track.URI, success: err == nil}
+	}
+}
+
+func sendUploadJob(telegramClient *TelegramClient, store *Store, job uploadJob) error {
+	track := job.track
+	performer := strings.Join(track.Artists, ", ")
+
+	sendCtx, cancel := context.WithTimeout(context.Background(), time.Minute*2)
+	defer cancel()
+
+	var fileID string
+	var err error
+
+	if job.fromCache {
+		log.Printf("Reusing cached upload for: %s", trackKey(track))
+		fileID, err = telegramClient.sendCachedAudio(sendCtx, job.fileID, track.Name, performer)
+	} else {
+		log.Printf("Uploading to Telegram: %s", trackKey(track))
+		fileID, err = telegramClient.sendOrEditAudio(sendCtx, job.audioPath, track.Name, performer, job.thumbPath)
+	}
+
+	if !job.fromCache {
+		os.Remove(job.audioPath)
+		if job.thumbPath != "" {
+			os.Remove(job.thumbPath)
+		}
+	}
+
+	if err != nil {
+		return fmt.Errorf("error uploading to telegram: %w", err)
+	}
+
+	if err := store.SaveTrack(track.URI, fileID, job.downloadedAt); err != nil {
+		return err
+	}
+	return store.SetNowPlayingMessageID(*telegramClient.messageID)
+}
+
+func trackKey(track *Track) string {
+	return fmt.Sprintf("%s - %s", track.Name, strings.Join(track.Artists, ", "))
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+const (
+	tracksBucket     = "tracks"
+	stateBucket      = "state"
+	nowPlayingKey    = "now_playing_message_id"
+	storeFileTimeout = 1 * time.Second
+)
+
+// TrackRecord is the cached upload state for a single Spotify track, keyed
+// by its URI so repeat plays can skip re-downloading and re-uploading audio.
+type TrackRecord struct {
+	FileID       string    `json:"file_id"`
+	DownloadedAt time.Time `json:"downloaded_at"`
+}
+
+// Store persists TrackRecords and the current "now playing" message id
+// across restarts in a bbolt database.
+type Store struct {
+	db *bolt.DB
+}
+
+func newStore(path string) (*Store, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: storeFileTimeout})
+	if err != nil {
+		return nil, fmt.Errorf("error opening store: %w", err)
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		if _, err := tx.CreateBucketIfNotExists([]byte(tracksBucket)); err != nil {
+			return err
+		}
+		_, err := tx.CreateBucketIfNotExists([]byte(stateBucket))
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("error initializing store buckets: %w", err)
+	}
+
+	return &Store{db: db}, nil
+}
+
+func (s *Store) Close() error {
+	return s.db.Close()
+}
+
+// GetTrack returns the cached record for trackURI, or nil if none exists.
+func (s *Store) GetTrack(trackURI string) (*TrackRecord, error) {
+	if trackURI == "" {
+		return nil, nil
+	}
+
+	var record *TrackRecord
+	err := s.db.View(func(tx *bolt.Tx) error {
+		raw := tx.Bucket([]byte(tracksBucket)).Get([]byte(trackURI))
+		if raw == nil {
+			return nil
+		}
+		record = &TrackRecord{}
+		return json.Unmarshal(raw, record)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error reading track record: %w", err)
+	}
+	return record, nil
+}
+
+// SaveTrack stores the upload result for trackURI so future plays of the
+// same track can be re-sent by file_id instead of re-downloaded.
+func (s *Store) SaveTrack(trackURI, fileID string, downloadedAt time.Time) error {
+	if trackURI == "" {
+		return nil
+	}
+
+	raw, err := json.Marshal(TrackRecord{FileID: fileID, DownloadedAt: downloadedAt})
+	if err != nil {
+		return fmt.Errorf("error encoding track record: %w", err)
+	}
+
+	err = s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte(tracksBucket)).Put([]byte(trackURI), raw)
+	})
+	if err != nil {
+		return fmt.Errorf("error saving track record: %w", err)
+	}
+	return nil
+}
+
+// GetNowPlayingMessageID returns the Telegram message id of the current
+// "now playing" message, if one has been recorded.
+func (s *Store) GetNowPlayingMessageID() (int, bool, error) {
+	var messageID int
+	found := false
+
+	err := s.db.View(func(tx *bolt.Tx) error {
+		raw := tx.Bucket([]byte(stateBucket)).Get([]byte(nowPlayingKey))
+		if raw == nil {
+			return nil
+		}
+		found = true
+		return json.Unmarshal(raw, &messageID)
+	})
+	if err != nil {
+		return 0, false, fmt.Errorf("error reading now-playing message id: %w", err)
+	}
+	return messageID, found, nil
+}
+
+// SetNowPlayingMessageID persists the Telegram message id that subsequent
+// ticks should edit instead of sending a fresh message.
+func (s *Store) SetNowPlayingMessageID(messageID int) error {
+	raw, err := json.Marshal(messageID)
+	if err != nil {
+		return fmt.Errorf("error encoding now-playing message id: %w", err)
+	}
+
+	err = s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte(stateBucket)).Put([]byte(nowPlayingKey), raw)
+	})
+	if err != nil {
+		return fmt.Errorf("error saving now-playing message id: %w", err)
+	}
+	return nil
+}